@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerifyWebhookToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		want   bool
+	}{
+		{"no secret configured", "", "", true},
+		{"no secret configured, header set anyway", "", "anything", true},
+		{"matching token", "s3cret", "s3cret", true},
+		{"mismatched token", "s3cret", "wrong", false},
+		{"missing header", "s3cret", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/message", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Gitlab-Token", tt.header)
+			}
+			if got := verifyWebhookToken(tt.secret, req); got != tt.want {
+				t.Errorf("verifyWebhookToken(%q, header=%q) = %v, want %v", tt.secret, tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayCacheSeenRecently(t *testing.T) {
+	c := newReplayCache(replayCacheSize)
+
+	if c.seenRecently("pipeline:1") {
+		t.Fatal("first delivery reported as a replay")
+	}
+	if !c.seenRecently("pipeline:1") {
+		t.Fatal("second delivery of the same key not reported as a replay")
+	}
+	if c.seenRecently("pipeline:2") {
+		t.Fatal("delivery of a different key reported as a replay")
+	}
+}
+
+func TestReplayCacheEvictsOldest(t *testing.T) {
+	c := newReplayCache(2)
+
+	c.seenRecently("a")
+	c.seenRecently("b")
+	c.seenRecently("c") // should evict "a"
+
+	if !c.seenRecently("b") {
+		t.Fatal("key within capacity no longer reported as seen")
+	}
+	if c.seenRecently("a") {
+		t.Fatal("evicted key still reported as seen")
+	}
+}
+
+func TestRejectIfReplayed(t *testing.T) {
+	cache := newReplayCache(replayCacheSize)
+	w := httptest.NewRecorder()
+
+	if rejectIfReplayed(cache, "pipeline", "1", w) {
+		t.Fatal("first delivery rejected as replayed")
+	}
+
+	w = httptest.NewRecorder()
+	if !rejectIfReplayed(cache, "pipeline", "1", w) {
+		t.Fatal("duplicate delivery not rejected as replayed")
+	}
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// mergeRequestTraceID derives a stable trace ID for the long-lived span
+// tracking one merge request, so every Merge Request Hook delivery for the
+// same MR (opened, updated, merged, closed) updates the same span instead
+// of creating a new one.
+func mergeRequestTraceID(projectID, iid int64) string {
+	h := md5.Sum([]byte(fmt.Sprintf("mr:%d:%d", projectID, iid)))
+	return hex.EncodeToString(h[:])
+}
+
+// MergeRequestEvent is the payload of a GitLab "Merge Request Hook".
+type MergeRequestEvent struct {
+	ObjectKind       string                     `json:"object_kind"`
+	User             User                       `json:"user"`
+	Project          Project                    `json:"project"`
+	ObjectAttributes MergeRequestHookAttributes `json:"object_attributes"`
+}
+
+type MergeRequestHookAttributes struct {
+	ID           int64  `json:"id"`
+	Iid          int64  `json:"iid"`
+	Title        string `json:"title"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	State        string `json:"state"`
+	// Action is GitLab's name for what changed about the MR on this
+	// delivery: open, update, merge or close.
+	Action    string `json:"action"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	URL       string `json:"url"`
+}
+
+// createTraceFromMergeRequest emits the long-lived span for a merge
+// request, keyed by project+IID so every hook delivery across the MR's
+// lifetime lands on the same span. The span's duration is only finalized
+// once the MR merges or closes; earlier deliveries report a span still in
+// progress. When a pipeline ran against an MR, createTraceFromPipeline adds
+// a span link from the pipeline's root span to this one; the pipeline's root
+// span is not a child of this span, since it keeps its own trace ID.
+func createTraceFromMergeRequest(sink TraceSink, mr MergeRequestEvent) error {
+	attrs := mr.ObjectAttributes
+	traceID := mergeRequestTraceID(mr.Project.ID, attrs.Iid)
+	span := Span{
+		ServiceName: "merge_request",
+		TraceID:     traceID,
+		SpanID:      traceID,
+		Name:        attrs.Title,
+		Status:      attrs.State,
+		Fields: map[string]interface{}{
+			"ci_provider":   "GitLab-CI",
+			"branch":        attrs.SourceBranch,
+			"target_branch": attrs.TargetBranch,
+			"pr_number":     attrs.Iid,
+			"pr_branch":     attrs.SourceBranch,
+			"repo":          mr.Project.WebURL,
+			"action":        attrs.Action,
+		},
+	}
+
+	created, err := parseTime(attrs.CreatedAt)
+	if err != nil {
+		log.Println("Failed to parse timestamp:", err)
+		fmt.Printf("%+v\n", span)
+		sink.Send(span)
+		return err
+	}
+	span.Timestamp = *created
+
+	if attrs.Action == "merge" || attrs.Action == "close" {
+		if updated, err := parseTime(attrs.UpdatedAt); err == nil {
+			span.Duration = updated.Sub(*created)
+		}
+	}
+
+	fmt.Printf("%+v\n", span)
+	return sink.Send(span)
+}
+
+func handleMergeRequest(sink TraceSink, rules *EnrichmentRules, cache *replayCache, w http.ResponseWriter, body []byte) {
+	var mr MergeRequestEvent
+	if err := json.Unmarshal(body, &mr); err != nil {
+		log.Print("Error unmarshalling request body.")
+		if _, printErr := fmt.Fprintf(w, "Error unmarshalling request body."); printErr != nil {
+			log.Print("Error printing error on error unmarshalling request body.")
+		}
+		return
+	}
+	if rejectIfReplayed(cache, "merge_request", fmt.Sprintf("%d:%s", mr.ObjectAttributes.ID, mr.ObjectAttributes.UpdatedAt), w) {
+		return
+	}
+	if err := createTraceFromMergeRequest(sink, mr); err != nil {
+		if errors.Is(err, ErrQueueFull) {
+			http.Error(w, "Event queue is full, please retry", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "Error creating trace from merge request object: %s", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Thanks!\n")
+}
+
+// pushSpanID derives a stable span ID for a push from its resulting commit
+// SHA, so a Pipeline Hook for that same SHA (carried in its "sha" field) can
+// be correlated back to the push that triggered it.
+func pushSpanID(checkoutSHA string) string {
+	h := md5.Sum([]byte("push:" + checkoutSHA))
+	return hex.EncodeToString(h[:])
+}
+
+// PushEvent is the payload of a GitLab "Push Hook".
+type PushEvent struct {
+	ObjectKind        string   `json:"object_kind"`
+	Before            string   `json:"before"`
+	After             string   `json:"after"`
+	Ref               string   `json:"ref"`
+	CheckoutSHA       string   `json:"checkout_sha"`
+	UserName          string   `json:"user_name"`
+	ProjectID         int64    `json:"project_id"`
+	Project           Project  `json:"project"`
+	Commits           []Commit `json:"commits"`
+	TotalCommitsCount int64    `json:"total_commits_count"`
+}
+
+// createTraceFromPush emits a short span for a push, keyed by the resulting
+// commit SHA so a later Pipeline Hook for that SHA can be correlated back
+// to it even though the two hooks arrive as unrelated HTTP requests.
+func createTraceFromPush(sink TraceSink, p PushEvent) error {
+	if p.CheckoutSHA == "" {
+		// Branch/tag deletions push a zero SHA; there's nothing to trace.
+		return nil
+	}
+	spanID := pushSpanID(p.CheckoutSHA)
+	span := Span{
+		ServiceName: "push",
+		TraceID:     spanID,
+		SpanID:      spanID,
+		Name:        "push " + p.Ref,
+		Timestamp:   time.Now(),
+		Fields: map[string]interface{}{
+			"ci_provider":  "GitLab-CI",
+			"branch":       p.Ref,
+			"sha":          p.CheckoutSHA,
+			"repo":         p.Project.WebURL,
+			"pusher":       p.UserName,
+			"commit_count": p.TotalCommitsCount,
+		},
+	}
+	if len(p.Commits) > 0 {
+		last := p.Commits[len(p.Commits)-1]
+		if timestamp, err := time.Parse(time.RFC3339, last.Timestamp); err == nil {
+			span.Timestamp = timestamp
+		}
+	}
+	fmt.Printf("%+v\n", span)
+	return sink.Send(span)
+}
+
+func handlePush(sink TraceSink, rules *EnrichmentRules, cache *replayCache, w http.ResponseWriter, body []byte) {
+	var push PushEvent
+	if err := json.Unmarshal(body, &push); err != nil {
+		log.Print("Error unmarshalling request body.")
+		if _, printErr := fmt.Fprintf(w, "Error unmarshalling request body."); printErr != nil {
+			log.Print("Error printing error on error unmarshalling request body.")
+		}
+		return
+	}
+	if rejectIfReplayed(cache, "push", fmt.Sprintf("%s:%s:%s", push.Ref, push.Before, push.After), w) {
+		return
+	}
+	if err := createTraceFromPush(sink, push); err != nil {
+		if errors.Is(err, ErrQueueFull) {
+			http.Error(w, "Event queue is full, please retry", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "Error creating trace from push object: %s", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Thanks!\n")
+}
+
+// deploymentSpanID derives a stable span ID for a deployment from its
+// deployment ID, the same way buildSpanID does for builds.
+func deploymentSpanID(deploymentID int64) string {
+	h := md5.Sum([]byte(fmt.Sprintf("deployment:%d", deploymentID)))
+	return hex.EncodeToString(h[:])
+}
+
+// DeploymentEvent is the payload of a GitLab "Deployment Hook".
+type DeploymentEvent struct {
+	ObjectKind      string  `json:"object_kind"`
+	Status          string  `json:"status"`
+	StatusChangedAt string  `json:"status_changed_at"`
+	DeploymentID    int64   `json:"deployment_id"`
+	DeployableID    int64   `json:"deployable_id"`
+	DeployableURL   string  `json:"deployable_url"`
+	Environment     string  `json:"environment"`
+	DeploymentTier  string  `json:"deployment_tier"`
+	PipelineID      int64   `json:"pipeline_id"`
+	ShortSHA        string  `json:"short_sha"`
+	Project         Project `json:"project"`
+	User            User    `json:"user"`
+}
+
+// createTraceFromDeployment emits a span for a deployment, linked to the
+// pipeline trace that produced it via trace.trace_id = pipeline_id: a
+// deployment is just another span in the pipeline's own trace, parented to
+// the pipeline's root span.
+func createTraceFromDeployment(sink TraceSink, d DeploymentEvent) error {
+	traceID := fmt.Sprint(d.PipelineID)
+	span := Span{
+		ServiceName: "deployment",
+		TraceID:     traceID,
+		SpanID:      deploymentSpanID(d.DeploymentID),
+		ParentID:    traceID,
+		Name:        "deploy " + d.Environment,
+		Status:      d.Status,
+		Fields: map[string]interface{}{
+			"ci_provider":     "GitLab-CI",
+			"environment":     d.Environment,
+			"deployable_url":  d.DeployableURL,
+			"deployment_tier": d.DeploymentTier,
+			"sha":             d.ShortSHA,
+			"repo":            d.Project.WebURL,
+		},
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, d.StatusChangedAt)
+	if err != nil {
+		log.Println("Failed to parse timestamp:", err)
+		fmt.Printf("%+v\n", span)
+		sink.Send(span)
+		return err
+	}
+	span.Timestamp = timestamp
+
+	fmt.Printf("%+v\n", span)
+	return sink.Send(span)
+}
+
+func handleDeployment(sink TraceSink, rules *EnrichmentRules, cache *replayCache, w http.ResponseWriter, body []byte) {
+	var deployment DeploymentEvent
+	if err := json.Unmarshal(body, &deployment); err != nil {
+		log.Print("Error unmarshalling request body.")
+		if _, printErr := fmt.Fprintf(w, "Error unmarshalling request body."); printErr != nil {
+			log.Print("Error printing error on error unmarshalling request body.")
+		}
+		return
+	}
+	if rejectIfReplayed(cache, "deployment", fmt.Sprintf("%d:%s", deployment.DeploymentID, deployment.Status), w) {
+		return
+	}
+	if err := createTraceFromDeployment(sink, deployment); err != nil {
+		if errors.Is(err, ErrQueueFull) {
+			http.Error(w, "Event queue is full, please retry", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "Error creating trace from deployment object: %s", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Thanks!\n")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreateTraceFromPushUsesLastCommitTimestamp(t *testing.T) {
+	sink := &recordingSink{}
+	p := PushEvent{
+		Ref:         "refs/heads/main",
+		CheckoutSHA: "abc123",
+		Commits: []Commit{
+			{Timestamp: "2021-04-28T21:50:00Z"},
+			{Timestamp: "2021-04-28T22:00:00Z"},
+		},
+	}
+	if err := createTraceFromPush(sink, p); err != nil {
+		t.Fatalf("createTraceFromPush: %v", err)
+	}
+
+	want, _ := time.Parse(time.RFC3339, "2021-04-28T22:00:00Z")
+	if !sink.spans[0].Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v (the last commit's timestamp)", sink.spans[0].Timestamp, want)
+	}
+}
+
+func TestCreateTraceFromPushSkipsDeletedRef(t *testing.T) {
+	sink := &recordingSink{}
+	p := PushEvent{Ref: "refs/heads/main", CheckoutSHA: ""}
+	if err := createTraceFromPush(sink, p); err != nil {
+		t.Fatalf("createTraceFromPush: %v", err)
+	}
+	if len(sink.spans) != 0 {
+		t.Errorf("got %d spans for a branch deletion, want 0", len(sink.spans))
+	}
+}
+
+func TestCreateTraceFromMergeRequestSharesTraceAndSpanID(t *testing.T) {
+	sink := &recordingSink{}
+	mr := MergeRequestEvent{
+		Project: Project{ID: 5},
+		ObjectAttributes: MergeRequestHookAttributes{
+			Iid:       3,
+			State:     "opened",
+			Action:    "open",
+			CreatedAt: "2021-04-28 21:50:00 UTC",
+		},
+	}
+	if err := createTraceFromMergeRequest(sink, mr); err != nil {
+		t.Fatalf("createTraceFromMergeRequest: %v", err)
+	}
+
+	span := sink.spans[0]
+	wantID := mergeRequestTraceID(mr.Project.ID, mr.ObjectAttributes.Iid)
+	if span.TraceID != wantID || span.SpanID != wantID {
+		t.Errorf("TraceID/SpanID = %q/%q, want both %q so repeated deliveries land on the same span", span.TraceID, span.SpanID, wantID)
+	}
+}
+
+func TestCreateTraceFromDeploymentParsesISO8601Timestamp(t *testing.T) {
+	sink := &recordingSink{}
+	d := DeploymentEvent{
+		DeploymentID:    11,
+		PipelineID:      99,
+		Environment:     "production",
+		StatusChangedAt: "2021-04-28T21:50:00Z",
+	}
+	if err := createTraceFromDeployment(sink, d); err != nil {
+		t.Fatalf("createTraceFromDeployment: %v", err)
+	}
+
+	want, _ := time.Parse(time.RFC3339, "2021-04-28T21:50:00Z")
+	if !sink.spans[0].Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v parsed as RFC3339 (real Deployment Hook payloads are ISO-8601, not GitLab's legacy log format)", sink.spans[0].Timestamp, want)
+	}
+}
+
+func TestCreateTraceFromDeploymentParentsUnderPipelineTrace(t *testing.T) {
+	sink := &recordingSink{}
+	d := DeploymentEvent{
+		DeploymentID:    11,
+		PipelineID:      99,
+		Environment:     "production",
+		StatusChangedAt: "2021-04-28T21:50:00Z",
+	}
+	if err := createTraceFromDeployment(sink, d); err != nil {
+		t.Fatalf("createTraceFromDeployment: %v", err)
+	}
+
+	span := sink.spans[0]
+	wantTraceID := "99"
+	if span.TraceID != wantTraceID || span.ParentID != wantTraceID {
+		t.Errorf("TraceID/ParentID = %q/%q, want both %q: a deployment shares its pipeline's trace_id and parents under its root span", span.TraceID, span.ParentID, wantTraceID)
+	}
+}
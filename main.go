@@ -4,15 +4,19 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/honeycombio/libhoney-go"
-	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 )
 
@@ -25,7 +29,10 @@ func home(w http.ResponseWriter, req *http.Request) {
 
 GET /healthz: healthcheck
 
-POST /api/message: receive array of notifications
+GET /metrics: Prometheus metrics (queue depth, send latency, retry counts)
+
+POST /api/message: receive a GitLab webhook (X-Gitlab-Event: Pipeline Hook,
+Job Hook, Merge Request Hook, Push Hook, or Deployment Hook)
 `)
 }
 
@@ -33,15 +40,7 @@ func healthz(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintf(w, "OK\n")
 }
 
-func createEvent(cfg *libhoney.Config) *libhoney.Event {
-	libhoney.UserAgentAddition = fmt.Sprintf("buildevents/%s", Version)
-	libhoney.UserAgentAddition += fmt.Sprintf(" (%s)", "GitLab-CI")
-
-	if cfg.APIKey == "" {
-		cfg.Transmission = &transmission.WriterSender{}
-	}
-	libhoney.Init(*cfg)
-
+func createEvent() *libhoney.Event {
 	ev := libhoney.NewEvent()
 	ev.AddField("ci_provider", "GitLab-CI")
 	ev.AddField("meta.version", Version)
@@ -63,96 +62,238 @@ func parseTime(dt string) (*time.Time, error) {
 	return &timestamp, nil
 }
 
-func createTraceFromPipeline(cfg *libhoney.Config, p Pipeline) (*libhoney.Event, error) {
+// buildSpanID deterministically derives a span ID for a single GitLab build
+// from its pipeline ID, stage and name. Both the Pipeline Hook (via
+// Pipeline.Builds) and the Job Hook describe the same underlying build, so
+// hashing the same three fields from either payload lets a late-arriving
+// Job Hook update the span the pipeline already created instead of creating
+// a duplicate.
+func buildSpanID(pipelineID int64, stage, name string) string {
+	h := md5.Sum([]byte(fmt.Sprintf("%d:%s:%s", pipelineID, stage, name)))
+	return hex.EncodeToString(h[:])
+}
+
+// stageSpanID derives a span ID for the synthetic span grouping the builds
+// of a single stage within a pipeline.
+func stageSpanID(pipelineID int64, stage string) string {
+	h := md5.Sum([]byte(fmt.Sprintf("stage:%d:%s", pipelineID, stage)))
+	return hex.EncodeToString(h[:])
+}
+
+func createTraceFromPipeline(sink TraceSink, p Pipeline, extraFields map[string]interface{}) error {
 	if p.ObjectAttributes.Status == "created" || p.ObjectAttributes.Status == "running" {
-		return nil, nil
+		return nil
 	}
 	traceID := fmt.Sprint(p.ObjectAttributes.ID)
-	ev := createEvent(cfg)
-	defer ev.Send()
 	buildURL := fmt.Sprintf("%s/-/pipelines/%d", p.Project.WebURL, p.ObjectAttributes.ID)
-	ev.Add(map[string]interface{}{
-		// Basic trace information
-		"service_name":   "pipeline",
-		"trace.span_id":  traceID,
-		"trace.trace_id": traceID,
-		"name":           "build " + traceID,
-
-		// CI information
-		"ci_provider": "GitLab-CI",
-		"branch":      p.ObjectAttributes.Ref,
-		"build_num":   p.ObjectAttributes.ID,
-		"build_url":   buildURL,
-		"pr_number":   p.MergeRequest.Iid,
-		"pr_branch":   p.MergeRequest.SourceBranch,
-		// TODO: Replace project Id with SOURCE_PROJECT_PATH
-		"pr_repo": p.MergeRequest.SourceProjectID,
-		"repo":    p.Project.WebURL,
-		// TODO: Something with pipeline status
-		"status": p.ObjectAttributes.Status,
-	})
-	if p.ObjectAttributes.Status != "created" && p.ObjectAttributes.Status != "running" {
-		ev.AddField("duration_ms", p.ObjectAttributes.Duration*1000)
-		ev.AddField("queued_duration_ms", p.ObjectAttributes.QueuedDuration*1000)
+	span := Span{
+		ServiceName: "pipeline",
+		TraceID:     traceID,
+		SpanID:      traceID,
+		Name:        "build " + traceID,
+		Status:      p.ObjectAttributes.Status,
+		Duration:    time.Duration(p.ObjectAttributes.Duration) * time.Second,
+		Fields: map[string]interface{}{
+			"ci_provider": "GitLab-CI",
+			"branch":      p.ObjectAttributes.Ref,
+			"build_num":   p.ObjectAttributes.ID,
+			"build_url":   buildURL,
+			"pr_number":   p.MergeRequest.Iid,
+			"pr_branch":   p.MergeRequest.SourceBranch,
+			// TODO: Replace project Id with SOURCE_PROJECT_PATH
+			"pr_repo":            p.MergeRequest.SourceProjectID,
+			"repo":               p.Project.WebURL,
+			"sha":                p.ObjectAttributes.SHA,
+			"queued_duration_ms": p.ObjectAttributes.QueuedDuration * 1000,
+		},
+	}
+	if p.MergeRequest.Iid != 0 {
+		// Links this pipeline to the long-lived merge_request span for
+		// p.MergeRequest.Iid. This has to be a link rather than ParentID:
+		// each pipeline keeps its own trace ID (so its stage/build spans
+		// can share it), and both Honeycomb and OTel only resolve a
+		// parent within a span's own trace, so a cross-trace ParentID
+		// would silently never render as a child of the MR span.
+		mrTraceID := mergeRequestTraceID(p.Project.ID, p.MergeRequest.Iid)
+		span.Links = append(span.Links, SpanLink{TraceID: mrTraceID, SpanID: mrTraceID})
+	}
+	for k, v := range extraFields {
+		span.Fields[k] = v
 	}
 
 	timestamp, err := parseTime(p.ObjectAttributes.CreatedAt)
 	// This error handling is a bit janky, I should tidy it up
 	if err != nil {
 		log.Println("Failed to parse timestamp:", err)
-		fmt.Printf("%+v\n", ev)
-		return ev, err
+		fmt.Printf("%+v\n", span)
+		sink.Send(span)
+		return err
+	}
+	span.Timestamp = *timestamp
+	fmt.Printf("%+v\n", span)
+
+	createStageAndBuildSpans(sink, p, traceID)
+
+	return sink.Send(span)
+}
+
+// createStageAndBuildSpans walks p.Builds and emits a span per build plus a
+// synthetic span per stage grouping those builds, so that the DAG described
+// by the Pipeline Hook's builds array is reconstructed without having to
+// wait on the corresponding Job Hooks. A build's span ID matches the one
+// createTraceFromJob would produce for the same build, so whichever hook
+// arrives second simply updates the existing span.
+func createStageAndBuildSpans(sink TraceSink, p Pipeline, traceID string) {
+	type stageWindow struct {
+		started  *time.Time
+		finished *time.Time
+	}
+	stages := map[string]*stageWindow{}
+	var stageOrder []string
+
+	for _, build := range p.Builds {
+		window, ok := stages[build.Stage]
+		if !ok {
+			window = &stageWindow{}
+			stages[build.Stage] = window
+			stageOrder = append(stageOrder, build.Stage)
+		}
+
+		started := parseOptionalTime(build.StartedAt)
+		finished := parseOptionalTime(build.FinishedAt)
+		if started != nil && (window.started == nil || started.Before(*window.started)) {
+			window.started = started
+		}
+		if finished != nil && (window.finished == nil || finished.After(*window.finished)) {
+			window.finished = finished
+		}
+
+		createTraceFromBuild(sink, p.ObjectAttributes.ID, traceID, stageSpanID(p.ObjectAttributes.ID, build.Stage), build, started, finished)
+	}
+
+	for _, stage := range stageOrder {
+		window := stages[stage]
+		span := Span{
+			ServiceName: "stage",
+			TraceID:     traceID,
+			SpanID:      stageSpanID(p.ObjectAttributes.ID, stage),
+			ParentID:    traceID,
+			Name:        stage,
+			Fields: map[string]interface{}{
+				"ci_provider": "GitLab-CI",
+			},
+		}
+		if window.started != nil {
+			span.Timestamp = *window.started
+			if window.finished != nil {
+				span.Duration = window.finished.Sub(*window.started)
+			}
+		} else {
+			// All-skipped or all-manual stages never see a started_at
+			// either; fall back the same way createTraceFromBuild does.
+			span.Timestamp = time.Now()
+		}
+		fmt.Printf("%+v\n", span)
+		if err := sink.Send(span); err != nil {
+			log.Println("Failed to enqueue stage span:", err)
+		}
+	}
+}
+
+// createTraceFromBuild emits a span for a single entry in Pipeline.Builds,
+// parented to the stage span it belongs to.
+func createTraceFromBuild(sink TraceSink, pipelineID int64, traceID, parentSpanID string, build Build, started, finished *time.Time) {
+	fields := map[string]interface{}{
+		"ci_provider":    "GitLab-CI",
+		"failure_reason": build.FailureReason,
+		"allow_failure":  build.AllowFailure,
+		"manual":         build.Manual,
+	}
+	if build.Runner != nil {
+		fields["runner.id"] = build.Runner.ID
+		fields["runner.tags"] = build.Runner.Tags
+	}
+	span := Span{
+		ServiceName: "job",
+		TraceID:     traceID,
+		SpanID:      buildSpanID(pipelineID, build.Stage, build.Name),
+		ParentID:    parentSpanID,
+		Name:        build.Name,
+		Status:      build.Status,
+		Fields:      fields,
+	}
+	if started != nil {
+		span.Timestamp = *started
+		if finished != nil {
+			span.Duration = finished.Sub(*started)
+		}
+	} else {
+		// Skipped jobs and un-played "when: manual" jobs never get a
+		// started_at, but we still want the build/stage span to show up
+		// somewhere sane rather than at Go's zero time (year 1).
+		span.Timestamp = time.Now()
+	}
+	fmt.Printf("%+v\n", span)
+	if err := sink.Send(span); err != nil {
+		log.Println("Failed to enqueue build span:", err)
+	}
+}
+
+// parseOptionalTime is parseTime for the *string timestamps GitLab sends for
+// builds that haven't started or finished yet.
+func parseOptionalTime(dt *string) *time.Time {
+	if dt == nil || *dt == "" {
+		return nil
+	}
+	timestamp, err := parseTime(*dt)
+	if err != nil {
+		log.Println("Failed to parse timestamp:", err)
+		return nil
 	}
-	ev.Timestamp = *timestamp
-	fmt.Printf("%+v\n", ev)
-	return ev, nil
+	return timestamp
 }
 
-func createTraceFromJob(cfg *libhoney.Config, j Job) (*libhoney.Event, error) {
+func createTraceFromJob(sink TraceSink, j Job, extraFields map[string]interface{}) error {
 	if j.BuildStatus == "created" || j.BuildStatus == "running" {
-		return nil, nil
+		return nil
 	}
 	parentTraceID := fmt.Sprint(j.PipelineID)
-	md5HashInBytes := md5.Sum([]byte(j.BuildName))
-	md5HashInString := hex.EncodeToString(md5HashInBytes[:])
-	spanID := md5HashInString
-	ev := createEvent(cfg)
-	defer ev.Send()
-	ev.Add(map[string]interface{}{
-		// Basic trace information
-		"service_name":    "job",
-		"trace.span_id":   spanID,
-		"trace.trace_id":  parentTraceID,
-		"trace.parent_id": parentTraceID,
-		"name":            fmt.Sprintf(j.BuildName),
-
-		// CI information
-		"ci_provider": "GitLab-CI",
-		"branch":      j.Ref,
-		"build_num":   j.PipelineID,
-		"build_id":    j.BuildID,
-		"repo":        j.Repository.Homepage,
-		// TODO: Something with job status
-		"status": j.BuildStatus,
-	})
-	if j.BuildStatus != "created" && j.BuildStatus != "running" {
-		ev.AddField("duration_ms", j.BuildDuration*1000)
-		ev.AddField("queued_duration_ms", j.BuildQueuedDuration*1000)
+	spanID := buildSpanID(j.PipelineID, j.BuildStage, j.BuildName)
+	span := Span{
+		ServiceName: "job",
+		TraceID:     parentTraceID,
+		SpanID:      spanID,
+		ParentID:    stageSpanID(j.PipelineID, j.BuildStage),
+		Name:        j.BuildName,
+		Status:      j.BuildStatus,
+		Duration:    time.Duration(j.BuildDuration * float64(time.Second)),
+		Fields: map[string]interface{}{
+			"ci_provider":        "GitLab-CI",
+			"branch":             j.Ref,
+			"build_num":          j.PipelineID,
+			"build_id":           j.BuildID,
+			"repo":               j.Repository.Homepage,
+			"queued_duration_ms": j.BuildQueuedDuration * 1000,
+		},
+	}
+	for k, v := range extraFields {
+		span.Fields[k] = v
 	}
 	timestamp, err := parseTime(j.BuildStartedAt)
 	// This error handling is a bit janky, I should tidy it up
 	if err != nil {
 		log.Println("Failed to parse timestamp:", err)
-		fmt.Printf("%+v\n", ev)
-		return ev, err
+		fmt.Printf("%+v\n", span)
+		sink.Send(span)
+		return err
 	}
-	ev.Timestamp = *timestamp
-	fmt.Printf("%+v\n", ev)
-	return ev, nil
+	span.Timestamp = *timestamp
+	fmt.Printf("%+v\n", span)
+	return sink.Send(span)
 }
 
 // buildevents build $CI_PIPELINE_ID $BUILD_START (failure|success)
-func handlePipeline(cfg *libhoney.Config, w http.ResponseWriter, body []byte) {
+func handlePipeline(sink TraceSink, rules *EnrichmentRules, cache *replayCache, w http.ResponseWriter, body []byte) {
 	var pipeline Pipeline
 	err := json.Unmarshal(body, &pipeline)
 	if err != nil {
@@ -163,16 +304,43 @@ func handlePipeline(cfg *libhoney.Config, w http.ResponseWriter, body []byte) {
 		}
 		return
 	}
-	_, err = createTraceFromPipeline(cfg, pipeline)
+	if rules.shouldDrop(pipeline.Project.PathWithNamespace, pipeline.ObjectAttributes.Ref) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Dropped by rules file.\n")
+		return
+	}
+	if rejectIfReplayed(cache, "pipeline", fmt.Sprintf("%d:%s", pipeline.ObjectAttributes.ID, pipeline.ObjectAttributes.Status), w) {
+		return
+	}
+	err = createTraceFromPipeline(sink, pipeline, rules.extraFields(body))
+	if errors.Is(err, ErrQueueFull) {
+		http.Error(w, "Event queue is full, please retry", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		fmt.Fprintf(w, "Error creating trace from pipeline object: %s", err)
 		return
 	}
+	w.WriteHeader(http.StatusAccepted)
 	fmt.Fprintf(w, "Thanks!\n")
 }
 
+// jobPathWithNamespace derives a project's path_with_namespace
+// ("group/project") from a Job Hook payload. Unlike Pipeline Hook, Job Hook
+// doesn't carry path_with_namespace directly -- only project_name, a
+// display name ("Group / Project") -- so a path_with_namespace_glob rule
+// written against the slug format is matched against the repository's web
+// URL path instead, falling back to project_name if that URL won't parse.
+func jobPathWithNamespace(j Job) string {
+	u, err := url.Parse(j.Repository.Homepage)
+	if err != nil || u.Path == "" {
+		return j.ProjectName
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
+
 // buildevents step $CI_PIPELINE_ID $STEP_SPAN_ID $STEP_START $CI_JOB_NAME
-func handleJob(cfg *libhoney.Config, w http.ResponseWriter, body []byte) {
+func handleJob(sink TraceSink, rules *EnrichmentRules, cache *replayCache, w http.ResponseWriter, body []byte) {
 	var job Job
 	err := json.Unmarshal(body, &job)
 	if err != nil {
@@ -183,20 +351,37 @@ func handleJob(cfg *libhoney.Config, w http.ResponseWriter, body []byte) {
 		}
 		return
 	}
+	if rules.shouldDrop(jobPathWithNamespace(job), job.Ref) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Dropped by rules file.\n")
+		return
+	}
+	if rejectIfReplayed(cache, "job", fmt.Sprintf("%d:%s", job.BuildID, job.BuildStatus), w) {
+		return
+	}
 	// fmt.Printf("%+v\n", job)
-	_, err = createTraceFromJob(cfg, job)
+	err = createTraceFromJob(sink, job, rules.extraFields(body))
+	if errors.Is(err, ErrQueueFull) {
+		http.Error(w, "Event queue is full, please retry", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		fmt.Fprintf(w, "Error creating trace from job object: %s", err)
 		return
 	}
+	w.WriteHeader(http.StatusAccepted)
 	fmt.Fprintf(w, "Thanks!\n")
 }
 
-func hello(cfg *libhoney.Config, w http.ResponseWriter, req *http.Request) {
+func hello(sink TraceSink, rules *EnrichmentRules, webhookSecret string, cache *replayCache, w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
 		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
 		return
 	}
+	if !verifyWebhookToken(webhookSecret, req) {
+		http.Error(w, "Invalid or missing X-Gitlab-Token", http.StatusUnauthorized)
+		return
+	}
 	eventHeaders := req.Header["X-Gitlab-Event"]
 	if len(eventHeaders) < 1 {
 		http.Error(w, "Missing header: X-Giitlab-Event", http.StatusBadRequest)
@@ -215,19 +400,37 @@ func hello(cfg *libhoney.Config, w http.ResponseWriter, req *http.Request) {
 		}
 		return
 	}
-	if eventType == "Pipeline Hook" {
-		fmt.Println("Received pipeline webhook:", string(body))
-		handlePipeline(cfg, w, body)
-	} else if eventType == "Job Hook" {
-		fmt.Println("Received job webhook:", string(body))
-		handleJob(cfg, w, body)
-	} else {
+	handler, ok := eventHandlers[eventType]
+	if !ok {
 		http.Error(w, fmt.Sprintf("Invalid event type: %s", eventType), http.StatusBadRequest)
 		return
 	}
+	fmt.Println("Received", eventType, "webhook:", string(body))
+	handler(sink, rules, cache, w, body)
+}
+
+// webhookHandler unmarshals one kind of GitLab webhook payload and traces
+// it, replying to w itself since the response shape (and HTTP status) can
+// depend on what the payload contains. rules is nil if no --rules-file was
+// configured; only handlePipeline and handleJob currently consult it.
+type webhookHandler func(sink TraceSink, rules *EnrichmentRules, cache *replayCache, w http.ResponseWriter, body []byte)
+
+// eventHandlers maps the X-Gitlab-Event header to the handler for that hook.
+// GitLab hook types handled:
+//   - "Pipeline Hook": a pipeline's overall status changed
+//   - "Job Hook": a single build within a pipeline changed status
+//   - "Merge Request Hook": an MR was opened, updated, merged or closed
+//   - "Push Hook": commits were pushed to a branch
+//   - "Deployment Hook": a deployment's status changed
+var eventHandlers = map[string]webhookHandler{
+	"Pipeline Hook":      handlePipeline,
+	"Job Hook":           handleJob,
+	"Merge Request Hook": handleMergeRequest,
+	"Push Hook":          handlePush,
+	"Deployment Hook":    handleDeployment,
 }
 
-func commandRoot(cfg *libhoney.Config, filename *string, ciProvider *string) *cobra.Command {
+func commandRoot(cfg *libhoney.Config, filename *string, ciProvider *string, webhookSecret *string, queueSize *int, retryLimit *int, sinkFlag *string, otlpEndpoint *string, otlpProtocol *string, otlpInsecure *bool, rulesFile *string) *cobra.Command {
 	root := &cobra.Command{
 		Version: Version,
 		Use:     "buildevents",
@@ -260,6 +463,46 @@ about your Continuous Integration builds.`,
 
 	root.PersistentFlags().StringVarP(ciProvider, "provider", "p", "GitLab-CI", "[env.BUILDEVENT_CIPROVIDER] if unset, will inspect the environment to try to detect common CI providers.")
 
+	root.PersistentFlags().StringVar(webhookSecret, "webhook-secret", "", "[env.GITLAB_WEBHOOK_SECRET] the shared secret GitLab sends as the X-Gitlab-Token header; requests without a matching token are rejected with 401")
+	if secret, ok := os.LookupEnv("GITLAB_WEBHOOK_SECRET"); ok {
+		root.PersistentFlags().Lookup("webhook-secret").Value.Set(secret)
+	}
+
+	root.PersistentFlags().IntVar(queueSize, "queue-size", 1000, "[env.GITLAB_QUEUE_SIZE] how many events may be buffered waiting to be sent to Honeycomb before new webhooks are rejected with 503")
+	if size, ok := os.LookupEnv("GITLAB_QUEUE_SIZE"); ok {
+		root.PersistentFlags().Lookup("queue-size").Value.Set(size)
+	}
+
+	root.PersistentFlags().IntVar(retryLimit, "retry-limit", 3, "[env.GITLAB_RETRY_LIMIT] how many times to retry sending an event to Honeycomb before giving up on it")
+	if limit, ok := os.LookupEnv("GITLAB_RETRY_LIMIT"); ok {
+		root.PersistentFlags().Lookup("retry-limit").Value.Set(limit)
+	}
+
+	root.PersistentFlags().StringVar(sinkFlag, "sink", "honeycomb", "[env.GITLAB_SINK] which tracing backend(s) to send spans to: honeycomb, otlp, or both")
+	if sink, ok := os.LookupEnv("GITLAB_SINK"); ok {
+		root.PersistentFlags().Lookup("sink").Value.Set(sink)
+	}
+
+	root.PersistentFlags().StringVar(otlpEndpoint, "otlp-endpoint", "localhost:4317", "[env.GITLAB_OTLP_ENDPOINT] host:port of the OTLP collector to send spans to, used when --sink is otlp or both")
+	if endpoint, ok := os.LookupEnv("GITLAB_OTLP_ENDPOINT"); ok {
+		root.PersistentFlags().Lookup("otlp-endpoint").Value.Set(endpoint)
+	}
+
+	root.PersistentFlags().StringVar(otlpProtocol, "otlp-protocol", "grpc", "[env.GITLAB_OTLP_PROTOCOL] protocol to speak to the OTLP collector: grpc or http")
+	if protocol, ok := os.LookupEnv("GITLAB_OTLP_PROTOCOL"); ok {
+		root.PersistentFlags().Lookup("otlp-protocol").Value.Set(protocol)
+	}
+
+	root.PersistentFlags().BoolVar(otlpInsecure, "otlp-insecure", false, "[env.GITLAB_OTLP_INSECURE] disable TLS when talking to the OTLP collector")
+	if insecure, ok := os.LookupEnv("GITLAB_OTLP_INSECURE"); ok {
+		root.PersistentFlags().Lookup("otlp-insecure").Value.Set(insecure)
+	}
+
+	root.PersistentFlags().StringVar(rulesFile, "rules-file", "", "[env.GITLAB_RULES_FILE] path to a YAML file configuring field enrichment, redaction and event filtering")
+	if rules, ok := os.LookupEnv("GITLAB_RULES_FILE"); ok {
+		root.PersistentFlags().Lookup("rules-file").Value.Set(rules)
+	}
+
 	return root
 }
 
@@ -268,9 +511,17 @@ func main() {
 	var config libhoney.Config
 	var filename string
 	var ciProvider string
+	var webhookSecret string
+	var queueSize int
+	var retryLimit int
+	var sinkFlag string
+	var otlpEndpoint string
+	var otlpProtocol string
+	var otlpInsecure bool
+	var rulesFile string
 	// var wcfg watchConfig
 
-	root := commandRoot(&config, &filename, &ciProvider)
+	root := commandRoot(&config, &filename, &ciProvider, &webhookSecret, &queueSize, &retryLimit, &sinkFlag, &otlpEndpoint, &otlpProtocol, &otlpInsecure, &rulesFile)
 
 	// Put 'em all together
 	root.AddCommand(
@@ -286,11 +537,26 @@ func main() {
 		os.Exit(1)
 	}
 	log.SetOutput(os.Stdout)
+	cache := newReplayCache(replayCacheSize)
+	dispatcher := NewEventDispatcher(&config, queueSize, retryLimit, prometheus.DefaultRegisterer)
+	dispatcher.Start()
+
+	sink, err := newTraceSink(dispatcher, sinkFlag, otlpEndpoint, otlpProtocol, otlpInsecure)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rules, err := loadEnrichmentRules(rulesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", healthz)
 	mux.HandleFunc("/api/message", func(rw http.ResponseWriter, r *http.Request) {
-		hello(&config, rw, r)
+		hello(sink, rules, webhookSecret, cache, rw, r)
 	})
+	mux.Handle("/metrics", promhttp.Handler())
 	mux.HandleFunc("/", home)
 
 	port := os.Getenv("PORT")
@@ -344,6 +610,7 @@ type Build struct {
 	When          string        `json:"when"`
 	Manual        bool          `json:"manual"`
 	AllowFailure  bool          `json:"allow_failure"`
+	FailureReason string        `json:"failure_reason"`
 	User          User          `json:"user"`
 	Runner        *Runner       `json:"runner"`
 	ArtifactsFile ArtifactsFile `json:"artifacts_file"`
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// ciPipelineIDKey, ciPipelineRunURLKey and vcsRepositoryURLKey mirror the
+// attribute names the OTel semantic conventions use for CI/CD and VCS data.
+// They're spelled out here rather than pulled from the generated semconv
+// package because that package doesn't yet stabilize the CI/CD namespace.
+const (
+	ciPipelineIDKey     = attribute.Key("ci.pipeline.id")
+	ciPipelineRunURLKey = attribute.Key("ci.pipeline.run.url")
+	vcsRepositoryURLKey = attribute.Key("vcs.repository.url")
+)
+
+// otlpMaxInFlight bounds how many of Send's background goroutines may be
+// running at once, each starting and ending one span. Send reports
+// ErrQueueFull once this many are running, the same backpressure signal
+// EventDispatcher.Enqueue gives the Honeycomb path. The export to the
+// collector happens later in the TracerProvider's batch processor and is
+// not counted here.
+const otlpMaxInFlight = 100
+
+// otlpSendTimeout is the deadline of the context each span is started with
+// in Send's background goroutine. It does not bound the export itself,
+// which the batch processor runs under its own timeout.
+const otlpSendTimeout = 10 * time.Second
+
+// OTLPSink delivers spans to an OTLP collector (Tempo, Jaeger, or any other
+// OTLP-compatible backend). GitLab's webhook payload already hands us fully
+// formed spans -- explicit IDs, timestamps and durations -- so this drives a
+// TracerProvider configured with a presetIDGenerator that hands those IDs
+// straight back instead of minting its own. Send itself only hands the span
+// off to a background goroutine, so a slow collector never blocks the
+// caller.
+type OTLPSink struct {
+	tracer   oteltrace.Tracer
+	inFlight chan struct{}
+}
+
+// NewOTLPSink dials the OTLP collector at endpoint over the given protocol
+// ("grpc" or "http") and returns a TraceSink backed by it.
+func NewOTLPSink(ctx context.Context, endpoint, protocol string, insecure bool) (*OTLPSink, error) {
+	exporter, err := newOTLPExporter(ctx, endpoint, protocol, insecure)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithIDGenerator(presetIDGenerator{}),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String("buildevents"))),
+	)
+	return &OTLPSink{
+		tracer:   provider.Tracer("github.com/zoidbergwill/gitlab-honeycomb-buildevents-webhooks-sink"),
+		inFlight: make(chan struct{}, otlpMaxInFlight),
+	}, nil
+}
+
+func newOTLPExporter(ctx context.Context, endpoint, protocol string, insecure bool) (sdktrace.SpanExporter, error) {
+	switch protocol {
+	case "grpc", "":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+		if insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case "http":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+		if insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown --otlp-protocol %q (want grpc or http)", protocol)
+	}
+}
+
+// Send starts and immediately ends a span carrying span's data on a
+// background goroutine, which hands the span to the TracerProvider's batch
+// processor for export. It returns ErrQueueFull once otlpMaxInFlight such
+// goroutines are already running.
+func (s *OTLPSink) Send(span Span) error {
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		return ErrQueueFull
+	}
+	go func() {
+		defer func() { <-s.inFlight }()
+		ctx, cancel := context.WithTimeout(context.Background(), otlpSendTimeout)
+		defer cancel()
+		s.emit(ctx, span)
+	}()
+	return nil
+}
+
+func (s *OTLPSink) emit(ctx context.Context, span Span) {
+	ctx = contextWithPresetIDs(ctx, otelTraceID(span.TraceID), otelSpanID(span.SpanID))
+	if span.ParentID != "" {
+		parent := oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+			TraceID:    otelTraceID(span.TraceID),
+			SpanID:     otelSpanID(span.ParentID),
+			TraceFlags: oteltrace.FlagsSampled,
+			Remote:     true,
+		})
+		ctx = oteltrace.ContextWithRemoteSpanContext(ctx, parent)
+	}
+	opts := []oteltrace.SpanStartOption{
+		oteltrace.WithTimestamp(span.Timestamp),
+		oteltrace.WithSpanKind(oteltrace.SpanKindInternal),
+	}
+	for _, link := range span.Links {
+		opts = append(opts, oteltrace.WithLinks(oteltrace.Link{
+			SpanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+				TraceID:    otelTraceID(link.TraceID),
+				SpanID:     otelSpanID(link.SpanID),
+				TraceFlags: oteltrace.FlagsSampled,
+				Remote:     true,
+			}),
+		}))
+	}
+	_, sp := s.tracer.Start(ctx, span.Name, opts...)
+	sp.SetAttributes(spanAttributes(span)...)
+	code, description := otelStatus(span.Status)
+	sp.SetStatus(code, description)
+
+	endTime := span.Timestamp
+	if span.Duration != 0 {
+		endTime = span.Timestamp.Add(span.Duration)
+	}
+	sp.End(oteltrace.WithTimestamp(endTime))
+}
+
+var _ TraceSink = (*OTLPSink)(nil)
+
+// otelTraceID and otelSpanID turn this program's opaque string IDs into the
+// fixed-width IDs OTel requires. They're deterministic so a Honeycomb trace
+// and an OTLP trace for the same webhook payload end up with matching
+// parent/child shape even though the two backends never compare notes.
+func otelTraceID(id string) oteltrace.TraceID {
+	return oteltrace.TraceID(md5.Sum([]byte(id)))
+}
+
+func otelSpanID(id string) oteltrace.SpanID {
+	sum := md5.Sum([]byte("span:" + id))
+	var spanID oteltrace.SpanID
+	copy(spanID[:], sum[:8])
+	return spanID
+}
+
+// otelStatus maps the ad-hoc status strings GitLab sends onto an OTel
+// status code, so a failed build shows up as an errored span in whatever
+// backend the OTLP collector forwards to.
+func otelStatus(status string) (codes.Code, string) {
+	switch status {
+	case "success":
+		return codes.Ok, status
+	case "failed", "canceled":
+		return codes.Error, status
+	default:
+		return codes.Unset, status
+	}
+}
+
+// spanAttributes translates a Span's generic Fields map into OTel
+// attributes, mapping a couple of field names onto their semantic
+// convention equivalents.
+func spanAttributes(span Span) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.ServiceNameKey.String(span.ServiceName)}
+	for k, v := range span.Fields {
+		switch value := v.(type) {
+		case string:
+			switch k {
+			case "build_url":
+				attrs = append(attrs, ciPipelineRunURLKey.String(value))
+			case "repo":
+				attrs = append(attrs, vcsRepositoryURLKey.String(value))
+			default:
+				attrs = append(attrs, attribute.String(k, value))
+			}
+		case int64:
+			if k == "build_num" {
+				attrs = append(attrs, ciPipelineIDKey.Int64(value))
+				continue
+			}
+			attrs = append(attrs, attribute.Int64(k, value))
+		case bool:
+			attrs = append(attrs, attribute.Bool(k, value))
+		default:
+			attrs = append(attrs, attribute.String(k, fmt.Sprint(value)))
+		}
+	}
+	return attrs
+}
+
+// presetIDsKey is the context key presetIDGenerator reads its IDs from.
+type presetIDsKey struct{}
+
+type presetIDs struct {
+	traceID oteltrace.TraceID
+	spanID  oteltrace.SpanID
+}
+
+// contextWithPresetIDs stashes the trace/span ID a span must use on ctx, for
+// presetIDGenerator to pick up when the TracerProvider starts that span.
+func contextWithPresetIDs(ctx context.Context, traceID oteltrace.TraceID, spanID oteltrace.SpanID) context.Context {
+	return context.WithValue(ctx, presetIDsKey{}, presetIDs{traceID: traceID, spanID: spanID})
+}
+
+// presetIDGenerator lets emit assign a span's TraceID/SpanID itself (derived
+// from GitLab's own opaque IDs via otelTraceID/otelSpanID) instead of
+// letting the SDK mint random ones, by stashing them on the context passed
+// to Tracer.Start. This is the supported way to control a recording span's
+// identity: sdktrace.ReadOnlySpan itself can't be implemented from outside
+// the SDK package.
+type presetIDGenerator struct{}
+
+func (presetIDGenerator) NewIDs(ctx context.Context) (oteltrace.TraceID, oteltrace.SpanID) {
+	if ids, ok := ctx.Value(presetIDsKey{}).(presetIDs); ok {
+		return ids.traceID, ids.spanID
+	}
+	return randomTraceID(), randomSpanID()
+}
+
+func (presetIDGenerator) NewSpanID(ctx context.Context, _ oteltrace.TraceID) oteltrace.SpanID {
+	if ids, ok := ctx.Value(presetIDsKey{}).(presetIDs); ok {
+		return ids.spanID
+	}
+	return randomSpanID()
+}
+
+func randomTraceID() oteltrace.TraceID {
+	var id oteltrace.TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func randomSpanID() oteltrace.SpanID {
+	var id oteltrace.SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
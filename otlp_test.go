@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestOtelTraceIDAndSpanIDAreDeterministic(t *testing.T) {
+	if otelTraceID("pipeline:1") != otelTraceID("pipeline:1") {
+		t.Error("otelTraceID is not deterministic for the same input")
+	}
+	if otelSpanID("span:1") != otelSpanID("span:1") {
+		t.Error("otelSpanID is not deterministic for the same input")
+	}
+	if otelTraceID("pipeline:1") == otelTraceID("pipeline:2") {
+		t.Error("otelTraceID produced the same ID for different inputs")
+	}
+}
+
+func TestOtelStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   codes.Code
+	}{
+		{"success", codes.Ok},
+		{"failed", codes.Error},
+		{"canceled", codes.Error},
+		{"running", codes.Unset},
+		{"", codes.Unset},
+	}
+	for _, tt := range tests {
+		if code, _ := otelStatus(tt.status); code != tt.want {
+			t.Errorf("otelStatus(%q) = %v, want %v", tt.status, code, tt.want)
+		}
+	}
+}
+
+func TestSpanAttributesMapsSemanticFields(t *testing.T) {
+	span := Span{
+		ServiceName: "pipeline",
+		Fields: map[string]interface{}{
+			"build_url": "https://example.com/pipelines/1",
+			"repo":      "https://example.com/group/project",
+			"build_num": int64(1),
+			"other":     "value",
+		},
+	}
+	attrs := spanAttributes(span)
+
+	byKey := map[string]interface{}{}
+	for _, attr := range attrs {
+		byKey[string(attr.Key)] = attr.Value.AsInterface()
+	}
+
+	if byKey[string(ciPipelineRunURLKey)] != "https://example.com/pipelines/1" {
+		t.Errorf("build_url not mapped to %s: got %v", ciPipelineRunURLKey, byKey[string(ciPipelineRunURLKey)])
+	}
+	if byKey[string(vcsRepositoryURLKey)] != "https://example.com/group/project" {
+		t.Errorf("repo not mapped to %s: got %v", vcsRepositoryURLKey, byKey[string(vcsRepositoryURLKey)])
+	}
+	if byKey[string(ciPipelineIDKey)] != int64(1) {
+		t.Errorf("build_num not mapped to %s: got %v", ciPipelineIDKey, byKey[string(ciPipelineIDKey)])
+	}
+	if byKey["other"] != "value" {
+		t.Errorf("unmapped field not passed through as-is: got %v", byKey["other"])
+	}
+}
+
+func TestPresetIDGeneratorReturnsStashedIDs(t *testing.T) {
+	traceID := otelTraceID("trace")
+	spanID := otelSpanID("span")
+	ctx := contextWithPresetIDs(context.Background(), traceID, spanID)
+
+	gen := presetIDGenerator{}
+	gotTraceID, gotSpanID := gen.NewIDs(ctx)
+	if gotTraceID != traceID || gotSpanID != spanID {
+		t.Errorf("NewIDs = %v/%v, want the stashed %v/%v", gotTraceID, gotSpanID, traceID, spanID)
+	}
+	if got := gen.NewSpanID(ctx, traceID); got != spanID {
+		t.Errorf("NewSpanID = %v, want the stashed %v", got, spanID)
+	}
+}
+
+func TestPresetIDGeneratorFallsBackToRandom(t *testing.T) {
+	gen := presetIDGenerator{}
+	traceID, spanID := gen.NewIDs(context.Background())
+	if (traceID == oteltrace.TraceID{}) {
+		t.Error("NewIDs returned a zero TraceID with no preset IDs on the context")
+	}
+	if (spanID == oteltrace.SpanID{}) {
+		t.Error("NewIDs returned a zero SpanID with no preset IDs on the context")
+	}
+}
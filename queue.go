@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrQueueFull is returned by EventDispatcher.Enqueue when the dispatcher's
+// buffered channel is saturated. Handlers map this to a 503 so that GitLab's
+// own webhook retry logic can redeliver the event later, instead of us
+// blocking the HTTP request (or silently dropping the event) on a Honeycomb
+// outage.
+var ErrQueueFull = errors.New("event queue is full")
+
+// retryBaseDelay and retryMaxDelay bound the exponential backoff applied
+// between retries of a single event, mirroring the DRONE_RETRY_LIMIT-style
+// flag pattern buildevents uses elsewhere: a small, capped number of
+// attempts rather than retrying forever.
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// retryMeta is stashed on libhoney.Event.Metadata so that when its send
+// result comes back on libhoney.TxResponses() we know how many times we've
+// already tried it.
+type retryMeta struct {
+	event    *libhoney.Event
+	attempts int
+}
+
+// EventDispatcher owns the single libhoney client for the process. Handlers
+// enqueue events onto it instead of calling ev.Send() themselves, so a
+// transient Honeycomb outage delays delivery (with retries) rather than
+// silently dropping the webhook.
+type EventDispatcher struct {
+	queue      chan *libhoney.Event
+	retryLimit int
+
+	queueDepth   prometheus.Gauge
+	sendLatency  prometheus.Histogram
+	retryCount   prometheus.Counter
+	droppedCount prometheus.Counter
+}
+
+// NewEventDispatcher initializes the libhoney client from cfg and returns a
+// dispatcher ready to have Start called on it. queueSize bounds how many
+// events may be buffered before Enqueue starts returning ErrQueueFull.
+func NewEventDispatcher(cfg *libhoney.Config, queueSize, retryLimit int, reg prometheus.Registerer) *EventDispatcher {
+	libhoney.UserAgentAddition = fmt.Sprintf("buildevents/%s", Version)
+	libhoney.UserAgentAddition += fmt.Sprintf(" (%s)", "GitLab-CI")
+
+	if cfg.APIKey == "" {
+		cfg.Transmission = &transmission.WriterSender{}
+	}
+	libhoney.Init(*cfg)
+
+	d := &EventDispatcher{
+		queue:      make(chan *libhoney.Event, queueSize),
+		retryLimit: retryLimit,
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "buildevents_queue_depth",
+			Help: "Number of events currently buffered waiting to be sent to Honeycomb.",
+		}),
+		sendLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "buildevents_send_latency_seconds",
+			Help: "Observed latency of sending an event to Honeycomb, as reported by libhoney.",
+		}),
+		retryCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "buildevents_send_retries_total",
+			Help: "Number of times an event send was retried after a failure.",
+		}),
+		droppedCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "buildevents_send_dropped_total",
+			Help: "Number of events dropped after exhausting the retry limit.",
+		}),
+	}
+	reg.MustRegister(d.queueDepth, d.sendLatency, d.retryCount, d.droppedCount)
+
+	return d
+}
+
+// Enqueue buffers ev for delivery, returning ErrQueueFull if the dispatcher
+// is saturated. It never blocks.
+func (d *EventDispatcher) Enqueue(ev *libhoney.Event) error {
+	select {
+	case d.queue <- ev:
+		d.queueDepth.Set(float64(len(d.queue)))
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Start runs the send loop and the response/retry loop in background
+// goroutines. It returns immediately; callers typically invoke it once from
+// main() before serving HTTP traffic.
+func (d *EventDispatcher) Start() {
+	go d.sendLoop()
+	go d.responseLoop()
+}
+
+func (d *EventDispatcher) sendLoop() {
+	for ev := range d.queue {
+		d.queueDepth.Set(float64(len(d.queue)))
+		d.send(ev, 0)
+	}
+}
+
+func (d *EventDispatcher) send(ev *libhoney.Event, attempts int) {
+	ev.Metadata = &retryMeta{event: ev, attempts: attempts}
+	if err := ev.Send(); err != nil {
+		// The event was rejected before it even reached libhoney's
+		// transmission layer (e.g. bad field types); nothing to retry.
+		d.droppedCount.Inc()
+	}
+}
+
+func (d *EventDispatcher) responseLoop() {
+	for resp := range libhoney.TxResponses() {
+		d.sendLatency.Observe(resp.Duration.Seconds())
+
+		if resp.Err == nil {
+			continue
+		}
+
+		meta, ok := resp.Metadata.(*retryMeta)
+		if !ok {
+			d.droppedCount.Inc()
+			continue
+		}
+
+		if meta.attempts >= d.retryLimit {
+			d.droppedCount.Inc()
+			continue
+		}
+
+		d.retryCount.Inc()
+		delay := backoff(meta.attempts)
+		attempts := meta.attempts + 1
+		time.AfterFunc(delay, func() {
+			d.send(meta.event, attempts)
+		})
+	}
+}
+
+// backoff returns a jittered exponential backoff for the given (zero-based)
+// attempt number, capped at retryMaxDelay.
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay) / 2))
+	return delay/2 + jitter
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/honeycombio/libhoney-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBackoffCapsAtMax(t *testing.T) {
+	for attempt := 0; attempt < 40; attempt++ {
+		delay := backoff(attempt)
+		if delay > retryMaxDelay {
+			t.Fatalf("backoff(%d) = %v, want <= %v", attempt, delay, retryMaxDelay)
+		}
+		if delay < 0 {
+			t.Fatalf("backoff(%d) = %v, want >= 0", attempt, delay)
+		}
+	}
+}
+
+func TestBackoffGrows(t *testing.T) {
+	// Early attempts should trend upward before hitting the cap. backoff
+	// returns delay/2 plus up to delay/2 of jitter, so attempt 0 falls in
+	// [250ms, 500ms) and attempt 3 in [2s, 4s): the ranges don't overlap,
+	// so any single pair of samples is ordered despite the jitter.
+	if b0, b3 := backoff(0), backoff(3); b0 >= b3 {
+		t.Fatalf("backoff did not grow with attempt count: attempt 0 = %v, attempt 3 = %v", b0, b3)
+	}
+}
+
+func TestEnqueueReturnsErrQueueFullWhenSaturated(t *testing.T) {
+	d := &EventDispatcher{
+		queue: make(chan *libhoney.Event, 1),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "test_queue_depth",
+		}),
+	}
+
+	if err := d.Enqueue(&libhoney.Event{}); err != nil {
+		t.Fatalf("first Enqueue returned %v, want nil", err)
+	}
+	if err := d.Enqueue(&libhoney.Event{}); err != ErrQueueFull {
+		t.Fatalf("second Enqueue on a full queue returned %v, want ErrQueueFull", err)
+	}
+}
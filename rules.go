@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnrichmentRules is the schema of the YAML file loaded via --rules-file.
+// It lets an operator tailor what this sink sends without recompiling it,
+// which a self-hosted, multi-tenant GitLab needs: the hardcoded field list
+// in createTraceFromPipeline/createTraceFromJob is both too much (PII like user emails) and too little (no way to
+// tag events with which team/environment/cluster they belong to).
+type EnrichmentRules struct {
+	// StaticFields are added to every event verbatim, e.g. team, environment
+	// or cluster.
+	StaticFields map[string]interface{} `yaml:"static_fields"`
+	// CopyFields pull a value out of the raw webhook JSON (using a
+	// JSONPath-like dotted path, an optional leading "$." is ignored) and
+	// add it to the event under a new field name.
+	CopyFields []CopyFieldRule `yaml:"copy_fields"`
+	// RedactFields drop or hash a CopyFields value whose source path
+	// matches Pattern, so PII like user.email never reaches the event.
+	RedactFields []RedactFieldRule `yaml:"redact_fields"`
+	// DropEvents filters out whole events by project or ref, so noisy or
+	// out-of-scope projects never get traced at all.
+	DropEvents []DropEventRule `yaml:"drop_events"`
+}
+
+// CopyFieldRule copies the value at From (a dotted path into the raw
+// webhook JSON) to a new field named To on the event.
+type CopyFieldRule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// RedactFieldRule matches Pattern against a CopyFieldRule's From path and,
+// on match, either drops the field (Mode: "drop") or replaces its value
+// with a SHA-256 hash of it (Mode: "hash").
+type RedactFieldRule struct {
+	Pattern string `yaml:"pattern"`
+	Mode    string `yaml:"mode"`
+
+	compiled *regexp.Regexp
+}
+
+// DropEventRule filters out an entire event when a glob matches. Either
+// field may be left empty to skip that check.
+type DropEventRule struct {
+	PathWithNamespaceGlob string `yaml:"path_with_namespace_glob"`
+	RefGlob               string `yaml:"ref_glob"`
+}
+
+// loadEnrichmentRules reads and compiles the rules file at path. An empty
+// path is not an error: it means no rules are configured, and shouldDrop
+// and extraFields are no-ops on a nil receiver.
+func loadEnrichmentRules(path string) (*EnrichmentRules, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rules file: %w", err)
+	}
+	var rules EnrichmentRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing rules file: %w", err)
+	}
+	for i, redact := range rules.RedactFields {
+		re, err := regexp.Compile(redact.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compiling redact pattern %q: %w", redact.Pattern, err)
+		}
+		rules.RedactFields[i].compiled = re
+	}
+	for _, drop := range rules.DropEvents {
+		if drop.PathWithNamespaceGlob != "" {
+			if _, err := filepath.Match(drop.PathWithNamespaceGlob, ""); err != nil {
+				return nil, fmt.Errorf("invalid path_with_namespace_glob %q: %w", drop.PathWithNamespaceGlob, err)
+			}
+		}
+		if drop.RefGlob != "" {
+			if _, err := filepath.Match(drop.RefGlob, ""); err != nil {
+				return nil, fmt.Errorf("invalid ref_glob %q: %w", drop.RefGlob, err)
+			}
+		}
+	}
+	return &rules, nil
+}
+
+// shouldDrop reports whether an event for the given project path and ref
+// should be filtered out entirely. Errors from filepath.Match are ignored
+// here because loadEnrichmentRules already validated every glob at startup.
+func (r *EnrichmentRules) shouldDrop(pathWithNamespace, ref string) bool {
+	if r == nil {
+		return false
+	}
+	for _, drop := range r.DropEvents {
+		if drop.PathWithNamespaceGlob != "" {
+			if matched, _ := filepath.Match(drop.PathWithNamespaceGlob, pathWithNamespace); matched {
+				return true
+			}
+		}
+		if drop.RefGlob != "" {
+			if matched, _ := filepath.Match(drop.RefGlob, ref); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extraFields builds the set of fields a rules file adds to an event for
+// this webhook delivery: the static fields plus whatever CopyFields pulls
+// out of raw, the undecoded webhook body. It's the "enrich" step called
+// from handlePipeline/handleJob after unmarshalling.
+func (r *EnrichmentRules) extraFields(raw []byte) map[string]interface{} {
+	if r == nil {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(r.StaticFields)+len(r.CopyFields))
+	for k, v := range r.StaticFields {
+		fields[k] = v
+	}
+	if len(r.CopyFields) == 0 {
+		return fields
+	}
+	var tree interface{}
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return fields
+	}
+	for _, cp := range r.CopyFields {
+		value := lookupPath(tree, strings.TrimPrefix(cp.From, "$."))
+		if value == nil {
+			continue
+		}
+		if mode, matched := r.redactMode(cp.From); matched {
+			if mode == "drop" {
+				continue
+			}
+			value = hashValue(value)
+		}
+		fields[cp.To] = value
+	}
+	return fields
+}
+
+// redactMode reports the RedactFieldRule.Mode of the first rule whose
+// Pattern matches path, if any.
+func (r *EnrichmentRules) redactMode(path string) (string, bool) {
+	for _, redact := range r.RedactFields {
+		if redact.compiled != nil && redact.compiled.MatchString(path) {
+			return redact.Mode, true
+		}
+	}
+	return "", false
+}
+
+// lookupPath walks a dot-separated path ("project.path_with_namespace")
+// through node, the generic map/slice tree produced by unmarshalling JSON
+// into an interface{}, returning nil if any segment is missing.
+func lookupPath(node interface{}, path string) interface{} {
+	if path == "" {
+		return node
+	}
+	cur := node
+	for _, segment := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur, ok = m[segment]
+		if !ok {
+			return nil
+		}
+	}
+	return cur
+}
+
+// hashValue renders v as a stable string and returns its SHA-256 hex
+// digest, used by RedactFieldRule's "hash" mode.
+func hashValue(v interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(v)))
+	return hex.EncodeToString(sum[:])
+}
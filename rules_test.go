@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRulesFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("writing rules file: %v", err)
+	}
+	return path
+}
+
+func TestLoadEnrichmentRulesEmptyPath(t *testing.T) {
+	rules, err := loadEnrichmentRules("")
+	if err != nil {
+		t.Fatalf("loadEnrichmentRules(\"\") returned %v, want nil error", err)
+	}
+	if rules != nil {
+		t.Fatalf("loadEnrichmentRules(\"\") returned %v, want nil rules", rules)
+	}
+}
+
+func TestLoadEnrichmentRulesRejectsBadRegex(t *testing.T) {
+	path := writeRulesFile(t, `
+redact_fields:
+  - pattern: "("
+    mode: drop
+`)
+	if _, err := loadEnrichmentRules(path); err == nil {
+		t.Fatal("loadEnrichmentRules with an invalid regex returned nil error, want one")
+	}
+}
+
+func TestLoadEnrichmentRulesRejectsBadGlob(t *testing.T) {
+	path := writeRulesFile(t, `
+drop_events:
+  - path_with_namespace_glob: "[]"
+`)
+	if _, err := loadEnrichmentRules(path); err == nil {
+		t.Fatal("loadEnrichmentRules with an invalid glob returned nil error, want one")
+	}
+}
+
+func TestShouldDropNilReceiver(t *testing.T) {
+	var rules *EnrichmentRules
+	if rules.shouldDrop("group/project", "main") {
+		t.Fatal("nil *EnrichmentRules reported a drop, want false")
+	}
+}
+
+func TestShouldDropMatchesGlobs(t *testing.T) {
+	path := writeRulesFile(t, `
+drop_events:
+  - path_with_namespace_glob: "noisy/*"
+  - ref_glob: "refs/tags/*"
+`)
+	rules, err := loadEnrichmentRules(path)
+	if err != nil {
+		t.Fatalf("loadEnrichmentRules: %v", err)
+	}
+
+	cases := []struct {
+		path, ref string
+		want      bool
+	}{
+		{"noisy/project", "main", true},
+		{"group/project", "refs/tags/v1", true},
+		{"group/project", "main", false},
+	}
+	for _, tc := range cases {
+		if got := rules.shouldDrop(tc.path, tc.ref); got != tc.want {
+			t.Errorf("shouldDrop(%q, %q) = %v, want %v", tc.path, tc.ref, got, tc.want)
+		}
+	}
+}
+
+func TestExtraFieldsStaticAndCopy(t *testing.T) {
+	path := writeRulesFile(t, `
+static_fields:
+  team: payments
+copy_fields:
+  - from: project.path_with_namespace
+    to: repo_slug
+  - from: user.email
+    to: user_email
+redact_fields:
+  - pattern: "user.email"
+    mode: hash
+`)
+	rules, err := loadEnrichmentRules(path)
+	if err != nil {
+		t.Fatalf("loadEnrichmentRules: %v", err)
+	}
+
+	raw := []byte(`{"project": {"path_with_namespace": "group/project"}, "user": {"email": "dev@example.com"}}`)
+	fields := rules.extraFields(raw)
+
+	if fields["team"] != "payments" {
+		t.Errorf("team = %v, want payments", fields["team"])
+	}
+	if fields["repo_slug"] != "group/project" {
+		t.Errorf("repo_slug = %v, want group/project", fields["repo_slug"])
+	}
+	want := hashValue("dev@example.com")
+	if fields["user_email"] != want {
+		t.Errorf("user_email = %v, want %v (hashed)", fields["user_email"], want)
+	}
+}
+
+func TestExtraFieldsRedactModeDrop(t *testing.T) {
+	path := writeRulesFile(t, `
+copy_fields:
+  - from: user.email
+    to: user_email
+redact_fields:
+  - pattern: "user.email"
+    mode: drop
+`)
+	rules, err := loadEnrichmentRules(path)
+	if err != nil {
+		t.Fatalf("loadEnrichmentRules: %v", err)
+	}
+
+	raw := []byte(`{"user": {"email": "dev@example.com"}}`)
+	fields := rules.extraFields(raw)
+	if _, ok := fields["user_email"]; ok {
+		t.Errorf("user_email = %v, want field dropped entirely", fields["user_email"])
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	tree := map[string]interface{}{
+		"project": map[string]interface{}{
+			"path_with_namespace": "group/project",
+		},
+	}
+	if got := lookupPath(tree, "project.path_with_namespace"); got != "group/project" {
+		t.Errorf("lookupPath = %v, want group/project", got)
+	}
+	if got := lookupPath(tree, "project.missing"); got != nil {
+		t.Errorf("lookupPath for missing segment = %v, want nil", got)
+	}
+	if got := lookupPath(tree, "project.path_with_namespace.extra"); got != nil {
+		t.Errorf("lookupPath past a leaf = %v, want nil", got)
+	}
+}
+
+func TestHashValue(t *testing.T) {
+	sum := sha256.Sum256([]byte("dev@example.com"))
+	want := hex.EncodeToString(sum[:])
+	if got := hashValue("dev@example.com"); got != want {
+		t.Errorf("hashValue = %q, want %q", got, want)
+	}
+	if got := hashValue(42); got == "" {
+		t.Errorf("hashValue(42) = %q, want non-empty", got)
+	}
+}
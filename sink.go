@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Span is a sink-agnostic description of one span produced by this program.
+// createTraceFromPipeline/Job/Build build one of these and hand it to
+// whichever TraceSink(s) are configured, instead of talking to libhoney (or
+// any other backend) directly.
+//
+// TraceID/SpanID/ParentID are opaque stable strings, not required to be in
+// any particular backend's ID format — each TraceSink is responsible for
+// turning them into whatever its backend expects. Using the same strings
+// across sinks is what lets a Honeycomb trace and an OTLP trace agree on
+// the same parent/child shape for the same webhook payload.
+type Span struct {
+	ServiceName string
+	TraceID     string
+	SpanID      string
+	ParentID    string // empty for a root span
+	Name        string
+	Timestamp   time.Time
+	Duration    time.Duration
+	Status      string
+	Fields      map[string]interface{}
+	// Links point at spans in other traces that this span relates to but
+	// isn't a child of, e.g. a pipeline linking back to the long-lived
+	// merge_request span it ran against. Both Honeycomb and OTel only
+	// resolve ParentID within a span's own trace_id, so a genuine
+	// cross-trace relationship has to be carried this way instead.
+	Links []SpanLink
+}
+
+// SpanLink references a span in another trace, for Span.Links.
+type SpanLink struct {
+	TraceID string
+	SpanID  string
+}
+
+// TraceSink delivers a Span to a tracing backend.
+type TraceSink interface {
+	Send(span Span) error
+}
+
+// multiSink fans a Span out to every configured sink, used for --sink=both.
+// It returns the first error encountered (if any) after sending to all
+// sinks, so one backend being unavailable doesn't stop the other from
+// receiving the span.
+type multiSink struct {
+	sinks []TraceSink
+}
+
+func newMultiSink(sinks ...TraceSink) TraceSink {
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+	return &multiSink{sinks: sinks}
+}
+
+func (m *multiSink) Send(span Span) error {
+	var firstErr error
+	for _, sink := range m.sinks {
+		if err := sink.Send(span); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// HoneycombSink delivers spans to Honeycomb via the durable EventDispatcher.
+type HoneycombSink struct {
+	dispatcher *EventDispatcher
+}
+
+func NewHoneycombSink(dispatcher *EventDispatcher) *HoneycombSink {
+	return &HoneycombSink{dispatcher: dispatcher}
+}
+
+func (s *HoneycombSink) Send(span Span) error {
+	ev := createEvent()
+	ev.Timestamp = span.Timestamp
+	fields := map[string]interface{}{
+		"service_name":   span.ServiceName,
+		"trace.trace_id": span.TraceID,
+		"trace.span_id":  span.SpanID,
+		"name":           span.Name,
+	}
+	if span.ParentID != "" {
+		fields["trace.parent_id"] = span.ParentID
+	}
+	if span.Status != "" {
+		fields["status"] = span.Status
+	}
+	if span.Duration != 0 {
+		fields["duration_ms"] = span.Duration.Milliseconds()
+	}
+	for k, v := range span.Fields {
+		fields[k] = v
+	}
+	ev.Add(fields)
+	if err := s.dispatcher.Enqueue(ev); err != nil {
+		return err
+	}
+	for _, link := range span.Links {
+		if err := s.sendLink(span, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendLink emits a synthetic zero-duration span-link event, Honeycomb's
+// documented way to record a span link: a child of span annotated with
+// meta.annotation_type=span_link and trace.link.trace_id/trace.link.span_id
+// pointing at the linked span, since a cross-trace relationship can't be
+// expressed with trace.parent_id (which Honeycomb, like OTel, only resolves
+// within one trace_id).
+func (s *HoneycombSink) sendLink(span Span, link SpanLink) error {
+	ev := createEvent()
+	ev.Timestamp = span.Timestamp
+	ev.AddField("trace.trace_id", span.TraceID)
+	ev.AddField("trace.parent_id", span.SpanID)
+	ev.AddField("trace.span_id", fmt.Sprintf("%s:link:%s", span.SpanID, link.SpanID))
+	ev.AddField("meta.annotation_type", "span_link")
+	ev.AddField("trace.link.trace_id", link.TraceID)
+	ev.AddField("trace.link.span_id", link.SpanID)
+	return s.dispatcher.Enqueue(ev)
+}
+
+var _ TraceSink = (*HoneycombSink)(nil)
+
+// newTraceSink builds the TraceSink selected by --sink. "both" fans spans
+// out to Honeycomb and an OTLP collector, which is useful while migrating
+// off Honeycomb: the new destination can be validated before the old one
+// is turned off.
+func newTraceSink(dispatcher *EventDispatcher, sinkFlag, otlpEndpoint, otlpProtocol string, otlpInsecure bool) (TraceSink, error) {
+	var sinks []TraceSink
+	if sinkFlag == "honeycomb" || sinkFlag == "both" {
+		sinks = append(sinks, NewHoneycombSink(dispatcher))
+	}
+	if sinkFlag == "otlp" || sinkFlag == "both" {
+		otlpSink, err := NewOTLPSink(context.Background(), otlpEndpoint, otlpProtocol, otlpInsecure)
+		if err != nil {
+			return nil, fmt.Errorf("initializing OTLP sink: %w", err)
+		}
+		sinks = append(sinks, otlpSink)
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("unknown --sink %q (want honeycomb, otlp, or both)", sinkFlag)
+	}
+	return newMultiSink(sinks...), nil
+}
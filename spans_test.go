@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// recordingSink collects every Span handed to Send, for assertions.
+type recordingSink struct {
+	spans []Span
+}
+
+func (s *recordingSink) Send(span Span) error {
+	s.spans = append(s.spans, span)
+	return nil
+}
+
+func findSpan(t *testing.T, sink *recordingSink, serviceName, name string) Span {
+	t.Helper()
+	for _, span := range sink.spans {
+		if span.ServiceName == serviceName && span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("no %s span named %q among %d spans sent", serviceName, name, len(sink.spans))
+	return Span{}
+}
+
+func TestCreateTraceFromJobParentsUnderStageSpan(t *testing.T) {
+	sink := &recordingSink{}
+	job := Job{
+		BuildStatus:    "success",
+		BuildStage:     "test",
+		BuildName:      "unit",
+		PipelineID:     42,
+		BuildStartedAt: "2021-04-28 21:50:00 UTC",
+	}
+	if err := createTraceFromJob(sink, job, nil); err != nil {
+		t.Fatalf("createTraceFromJob: %v", err)
+	}
+
+	span := sink.spans[0]
+	wantParent := stageSpanID(job.PipelineID, job.BuildStage)
+	if span.ParentID != wantParent {
+		t.Errorf("ParentID = %q, want %q (stage span, matching createTraceFromBuild)", span.ParentID, wantParent)
+	}
+}
+
+func TestCreateTraceFromBuildAndJobAgreeOnSpanID(t *testing.T) {
+	job := Job{
+		BuildStatus:    "success",
+		BuildStage:     "test",
+		BuildName:      "unit",
+		PipelineID:     42,
+		BuildStartedAt: "2021-04-28 21:50:00 UTC",
+	}
+	build := Build{Stage: job.BuildStage, Name: job.BuildName}
+
+	jobSink := &recordingSink{}
+	if err := createTraceFromJob(jobSink, job, nil); err != nil {
+		t.Fatalf("createTraceFromJob: %v", err)
+	}
+	buildSink := &recordingSink{}
+	createTraceFromBuild(buildSink, job.PipelineID, "42", stageSpanID(job.PipelineID, build.Stage), build, nil, nil)
+
+	jobSpanID := jobSink.spans[0].SpanID
+	buildSpanIDForBuild := buildSink.spans[0].SpanID
+	if jobSpanID != buildSpanIDForBuild {
+		t.Errorf("buildSpanID disagreed between Job and Build payloads for the same build: %q vs %q", jobSpanID, buildSpanIDForBuild)
+	}
+}
+
+func TestCreateTraceFromBuildFallsBackToNowWithNoStartedAt(t *testing.T) {
+	sink := &recordingSink{}
+	build := Build{Stage: "test", Name: "unit", Status: "skipped"}
+
+	before := time.Now()
+	createTraceFromBuild(sink, 1, "1", stageSpanID(1, "test"), build, nil, nil)
+	after := time.Now()
+
+	span := sink.spans[0]
+	if span.Timestamp.Before(before) || span.Timestamp.After(after) {
+		t.Errorf("Timestamp = %v, want a time between %v and %v (time.Now() fallback), not the zero value", span.Timestamp, before, after)
+	}
+}
+
+func TestCreateStageAndBuildSpansFallsBackToNowForAllSkippedStage(t *testing.T) {
+	sink := &recordingSink{}
+	p := Pipeline{
+		ObjectAttributes: PipelineObjectAttributes{ID: 7},
+		Builds: []Build{
+			{Stage: "test", Name: "unit", Status: "skipped"},
+		},
+	}
+
+	before := time.Now()
+	createStageAndBuildSpans(sink, p, "7")
+	after := time.Now()
+
+	stage := findSpan(t, sink, "stage", "test")
+	if stage.Timestamp.Before(before) || stage.Timestamp.After(after) {
+		t.Errorf("stage Timestamp = %v, want a time between %v and %v, not the zero value", stage.Timestamp, before, after)
+	}
+}
+
+func TestCreateTraceFromPipelineLinksToMergeRequestSpan(t *testing.T) {
+	sink := &recordingSink{}
+	p := Pipeline{
+		ObjectAttributes: PipelineObjectAttributes{
+			ID:        99,
+			Status:    "success",
+			CreatedAt: "2021-04-28 21:50:00 UTC",
+		},
+		Project:      Project{ID: 5},
+		MergeRequest: MergeRequest{Iid: 3},
+	}
+
+	if err := createTraceFromPipeline(sink, p, nil); err != nil {
+		t.Fatalf("createTraceFromPipeline: %v", err)
+	}
+
+	span := findSpan(t, sink, "pipeline", "build 99")
+	if span.ParentID != "" {
+		t.Errorf("ParentID = %q, want empty: a pipeline's trace_id is its own, so a cross-trace MR relationship must not be expressed as ParentID", span.ParentID)
+	}
+
+	wantTraceID := mergeRequestTraceID(p.Project.ID, p.MergeRequest.Iid)
+	if len(span.Links) != 1 {
+		t.Fatalf("Links = %v, want exactly one link to the merge_request span", span.Links)
+	}
+	if span.Links[0].TraceID != wantTraceID || span.Links[0].SpanID != wantTraceID {
+		t.Errorf("Links[0] = %+v, want {TraceID: %q, SpanID: %q}", span.Links[0], wantTraceID, wantTraceID)
+	}
+}